@@ -0,0 +1,122 @@
+package mitch
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// CDNAsset is anything that can be resolved to a CDN-hosted file: a
+// hosted upload (keyed by its own URL) or a single build file (keyed by
+// its own path).
+type CDNAsset interface {
+	CDNPath() string
+}
+
+func (u *Upload) CDNPath() string {
+	return u.URL
+}
+
+func (f *CDNFile) CDNPath() string {
+	return f.Path
+}
+
+// ServeCDNAsset resolves asset to its backing CDNFile and streams it
+// the same way the `/@cdn` endpoint does, Range requests included (see
+// serveCDNFile). Uploads stored on an external "gcs" or "s3"
+// backend are 302-redirected to a signed URL instead, so client code
+// that branches on cloud-storage redirects can be exercised without
+// mitch having to serve the bytes itself.
+func (r *response) ServeCDNAsset(asset CDNAsset) {
+	if upload, ok := asset.(*Upload); ok {
+		switch upload.Storage {
+		case "gcs", "s3":
+			url, err := r.store.SignedURL(upload.CDNPath(), 15*time.Minute)
+			if err != nil {
+				Throw(500, fmt.Sprintf("could not sign url: %v", err))
+			}
+			r.Header().Set("Location", url)
+			r.status = 302
+			r.WriteHeader()
+			return
+		}
+	}
+
+	f := r.store.FindCDNFile(asset.CDNPath())
+	if f == nil {
+		Throw(404, "not found")
+	}
+	serveCDNFile(r, f)
+}
+
+// serveCDNFile writes f's bytes to r. Range handling (single ranges,
+// suffix ranges, open-ended ranges, multipart/byteranges, 416 on bad
+// ranges) is delegated entirely to http.ServeContent. CDN files are
+// served with no modification time and no ETag, so If-Modified-Since is
+// ignored and an If-Range condition never matches (the whole file is
+// sent instead). It's the shared core behind both `/@cdn/...` and
+// ServeCDNAsset.
+func serveCDNFile(r *response, f *CDNFile) {
+	data := f.Contents
+	if r.fault != nil && r.fault.CorruptLength > 0 {
+		data = corruptBytes(data, r.fault.CorruptOffset, r.fault.CorruptLength)
+	}
+
+	// Set these explicitly so ServeContent doesn't sniff the content
+	// type and so we keep offering the file as a download.
+	r.w.Header().Set("content-type", "application/octet-stream")
+	r.w.Header().Set("content-disposition", fmt.Sprintf("attachment; filename=%q", f.Filename))
+
+	w := http.ResponseWriter(r.w)
+	if r.fault != nil && r.fault.TruncateAt > 0 && r.fault.TruncateAt < int64(len(data)) {
+		w = &truncatingWriter{ResponseWriter: r.w, limit: r.fault.TruncateAt}
+	}
+
+	log.Printf("Serving %s", f.Filename)
+	http.ServeContent(w, r.req, f.Filename, time.Time{}, bytes.NewReader(data))
+	log.Printf("Serving %s (done)", f.Filename)
+}
+
+// truncatingWriter caps the number of bytes written through it and, once
+// the cap is hit, hijacks and closes the underlying connection instead
+// of letting the response complete normally. Used by fault injection to
+// simulate a download dying partway through.
+type truncatingWriter struct {
+	http.ResponseWriter
+	limit    int64
+	written  int64
+	hijacked bool
+}
+
+func (tw *truncatingWriter) Write(p []byte) (int, error) {
+	if tw.hijacked {
+		return 0, http.ErrHandlerTimeout
+	}
+
+	remaining := tw.limit - tw.written
+	if remaining <= 0 {
+		tw.hijack()
+		return 0, http.ErrHandlerTimeout
+	}
+	if int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	n, err := tw.ResponseWriter.Write(p)
+	tw.written += int64(n)
+	if tw.written >= tw.limit {
+		tw.hijack()
+	}
+	return n, err
+}
+
+func (tw *truncatingWriter) hijack() {
+	tw.hijacked = true
+	if hj, ok := tw.ResponseWriter.(http.Hijacker); ok {
+		if conn, _, err := hj.Hijack(); err == nil {
+			conn.Close()
+		}
+	}
+}
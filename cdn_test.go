@@ -0,0 +1,83 @@
+package mitch
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+// TestCDNRangeRequests exercises the Range handling that serveCDNFile
+// delegates to http.ServeContent: a single range, a suffix range, an
+// open-ended range, and an out-of-bounds range that must 416 rather than
+// the 500 the old ad-hoc parser used to produce.
+func TestCDNRangeRequests(t *testing.T) {
+	base, store := newTestServer(t)
+
+	contents := []byte("0123456789ABCDEF")
+	store.PutCDNFile("/game.bin", &CDNFile{Filename: "game.bin", Size: int64(len(contents)), Contents: contents})
+
+	client := &http.Client{}
+
+	cases := []struct {
+		name       string
+		rangeHdr   string
+		wantStatus int
+		wantBody   string
+	}{
+		{"single range", "bytes=2-5", 206, "2345"},
+		{"suffix range", "bytes=-4", 206, "CDEF"},
+		{"open-ended range", "bytes=10-", 206, "ABCDEF"},
+		{"out of bounds range", "bytes=100-200", 416, ""},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := newAuthedRequest(t, "GET", base+"/@cdn/game.bin", nil)
+			req.Header.Set("Range", tc.rangeHdr)
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("request: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tc.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tc.wantStatus)
+			}
+			if tc.wantStatus != 206 {
+				return
+			}
+			got, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("reading body: %v", err)
+			}
+			if string(got) != tc.wantBody {
+				t.Fatalf("body = %q, want %q", got, tc.wantBody)
+			}
+		})
+	}
+}
+
+// TestCDNNoRangeServesWholeFile verifies a plain request (no Range
+// header) still gets the full file back with a 200.
+func TestCDNNoRangeServesWholeFile(t *testing.T) {
+	base, store := newTestServer(t)
+
+	contents := []byte("full file contents")
+	store.PutCDNFile("/game.bin", &CDNFile{Filename: "game.bin", Size: int64(len(contents)), Contents: contents})
+
+	client := &http.Client{}
+	resp, err := client.Do(newAuthedRequest(t, "GET", base+"/@cdn/game.bin", nil))
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	got, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	if string(got) != string(contents) {
+		t.Fatalf("body = %q, want %q", got, contents)
+	}
+}
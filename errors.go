@@ -0,0 +1,25 @@
+package mitch
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// APIError is a typed error carrying the HTTP status and messages that
+// should be written back to the client. It's recovered from panics in
+// the top-level request handler, see server.go.
+type APIError struct {
+	status   int
+	messages []string
+}
+
+func (ae APIError) Error() string {
+	return fmt.Sprintf("api error %d: %v", ae.status, ae.messages)
+}
+
+// Throw panics with an APIError, to be caught by the handler wrapper in
+// server.go and turned into a JSON error response.
+func Throw(status int, messages ...string) {
+	panic(errors.WithStack(APIError{status: status, messages: messages}))
+}
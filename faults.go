@@ -0,0 +1,120 @@
+package mitch
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FaultRule describes one deterministic misbehavior to inject on
+// requests matching PathGlob and Method (an empty Method matches any).
+// Rules are consumed both by the top-level request dispatcher (latency,
+// forced status codes, range rejection) and by the CDN byte-serving
+// code (truncation, corruption) in cdn.go, so a test can make a
+// download fail once and succeed on resume.
+type FaultRule struct {
+	PathGlob string
+	Method   string
+
+	// OnHit, if > 0, makes the rule only fire on the Nth matching
+	// request (1-indexed); 0 means every matching request fires it.
+	OnHit int
+
+	Latency     time.Duration
+	StatusOnHit int
+	RejectRange bool
+
+	// TruncateAt, if > 0, cuts a CDN response body at that many bytes
+	// and drops the connection instead of closing it cleanly.
+	TruncateAt int64
+
+	// CorruptOffset/CorruptLength, if CorruptLength > 0, flip every byte
+	// in that range of a CDN response body.
+	CorruptOffset int64
+	CorruptLength int64
+
+	hits int
+}
+
+func (fr *FaultRule) matches(method, path string) bool {
+	if fr.Method != "" && fr.Method != method {
+		return false
+	}
+	ok, err := filepath.Match(fr.PathGlob, path)
+	return err == nil && ok
+}
+
+// FaultRules is a Store's registry of active fault-injection rules.
+// It's only consulted when the server is started WithFaultInjection.
+type FaultRules struct {
+	mu    sync.Mutex
+	rules []*FaultRule
+}
+
+func newFaultRules() *FaultRules {
+	return &FaultRules{}
+}
+
+// Add registers rule, returning it so the caller can later Remove it or
+// inspect the hit count it accumulates.
+func (fr *FaultRules) Add(rule *FaultRule) *FaultRule {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	fr.rules = append(fr.rules, rule)
+	return rule
+}
+
+// Remove unregisters rule.
+func (fr *FaultRules) Remove(rule *FaultRule) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	for i, r := range fr.rules {
+		if r == rule {
+			fr.rules = append(fr.rules[:i], fr.rules[i+1:]...)
+			return
+		}
+	}
+}
+
+// Clear removes every registered rule.
+func (fr *FaultRules) Clear() {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	fr.rules = nil
+}
+
+// Match walks the rules in registration order, bumping the hit counter
+// of each one matching method+path, and returns the first that fires on
+// this request (nil if none does). Rules after the returned one are not
+// counted.
+func (fr *FaultRules) Match(method, path string) *FaultRule {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	for _, r := range fr.rules {
+		if !r.matches(method, path) {
+			continue
+		}
+		r.hits++
+		if r.OnHit == 0 || r.OnHit == r.hits {
+			return r
+		}
+	}
+	return nil
+}
+
+// corruptBytes returns a copy of data with every byte in
+// [offset, offset+length) flipped.
+func corruptBytes(data []byte, offset, length int64) []byte {
+	out := make([]byte, len(data))
+	copy(out, data)
+
+	end := offset + length
+	if end > int64(len(out)) {
+		end = int64(len(out))
+	}
+	for i := offset; i >= 0 && i < end; i++ {
+		out[i] ^= 0xFF
+	}
+	return out
+}
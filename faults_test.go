@@ -0,0 +1,127 @@
+package mitch
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+// TestFaultRuleOnHitFiresOnceThenResumes verifies OnHit's "fire on the
+// Nth matching request only" semantics, so a test can make a first
+// request fail and a resumed/retried one succeed.
+func TestFaultRuleOnHitFiresOnceThenResumes(t *testing.T) {
+	base, store := newTestServer(t, WithFaultInjection())
+
+	store.Games[1] = &Game{ID: 1}
+	store.Uploads[1] = &Upload{ID: 1, GameID: 1, Storage: "hosted", URL: "/uploads/1/archive", Filename: "game.bin"}
+	store.PutCDNFile("/uploads/1/archive", &CDNFile{Filename: "game.bin", Size: 4, Contents: []byte("data")})
+
+	store.FaultRules.Add(&FaultRule{
+		PathGlob:    "/uploads/1/download",
+		Method:      "GET",
+		OnHit:       1,
+		StatusOnHit: 503,
+	})
+
+	client := &http.Client{}
+
+	firstResp, err := client.Do(newAuthedRequest(t, "GET", base+"/uploads/1/download", nil))
+	if err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+	firstResp.Body.Close()
+	if firstResp.StatusCode != 503 {
+		t.Fatalf("first request: expected 503, got %d", firstResp.StatusCode)
+	}
+
+	secondResp, err := client.Do(newAuthedRequest(t, "GET", base+"/uploads/1/download", nil))
+	if err != nil {
+		t.Fatalf("second request: %v", err)
+	}
+	defer secondResp.Body.Close()
+	if secondResp.StatusCode != 200 {
+		t.Fatalf("second request: expected 200, got %d", secondResp.StatusCode)
+	}
+	got, err := ioutil.ReadAll(secondResp.Body)
+	if err != nil {
+		t.Fatalf("reading second response: %v", err)
+	}
+	if string(got) != "data" {
+		t.Fatalf("second response body = %q, want %q", got, "data")
+	}
+}
+
+// TestFaultRuleCorruption verifies a CorruptOffset/CorruptLength rule
+// flips exactly the requested byte range of a CDN download.
+func TestFaultRuleCorruption(t *testing.T) {
+	base, store := newTestServer(t, WithFaultInjection())
+
+	store.Games[1] = &Game{ID: 1}
+	store.Uploads[1] = &Upload{ID: 1, GameID: 1, Storage: "hosted", URL: "/uploads/1/archive", Filename: "game.bin"}
+	original := []byte("0123456789")
+	store.PutCDNFile("/uploads/1/archive", &CDNFile{Filename: "game.bin", Size: int64(len(original)), Contents: original})
+
+	store.FaultRules.Add(&FaultRule{
+		PathGlob:      "/uploads/1/download",
+		Method:        "GET",
+		CorruptOffset: 2,
+		CorruptLength: 3,
+	})
+
+	client := &http.Client{}
+	resp, err := client.Do(newAuthedRequest(t, "GET", base+"/uploads/1/download", nil))
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	got, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+
+	want := corruptBytes(original, 2, 3)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("corrupted body = %q, want %q", got, want)
+	}
+	if bytes.Equal(got[:2], original[:2]) == false {
+		t.Fatalf("bytes before corrupted range should be untouched")
+	}
+}
+
+// TestFaultRuleTruncation verifies a TruncateAt rule cuts a CDN download
+// short instead of letting it complete normally.
+func TestFaultRuleTruncation(t *testing.T) {
+	base, store := newTestServer(t, WithFaultInjection())
+
+	store.Games[1] = &Game{ID: 1}
+	store.Uploads[1] = &Upload{ID: 1, GameID: 1, Storage: "hosted", URL: "/uploads/1/archive", Filename: "game.bin"}
+	original := bytes.Repeat([]byte("x"), 100)
+	store.PutCDNFile("/uploads/1/archive", &CDNFile{Filename: "game.bin", Size: int64(len(original)), Contents: original})
+
+	store.FaultRules.Add(&FaultRule{
+		PathGlob:   "/uploads/1/download",
+		Method:     "GET",
+		TruncateAt: 10,
+	})
+
+	client := &http.Client{}
+	resp, err := client.Do(newAuthedRequest(t, "GET", base+"/uploads/1/download", nil))
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	got, readErr := ioutil.ReadAll(resp.Body)
+	if readErr == nil {
+		t.Fatalf("expected a truncated read to error out, got a clean %d-byte body", len(got))
+	}
+	if int64(len(got)) > 10 {
+		t.Fatalf("read %d bytes, expected at most the 10-byte TruncateAt limit", len(got))
+	}
+}
@@ -0,0 +1,6 @@
+package mitch
+
+// Any is a loosely-typed JSON object, used throughout the formatters and
+// response helpers to build API payloads without declaring a struct for
+// every endpoint.
+type Any map[string]interface{}
@@ -0,0 +1,119 @@
+package mitch
+
+import "sync"
+
+// User is a minimal stand-in for an itch.io account.
+type User struct {
+	ID             int64
+	Gamer          bool
+	Developer      bool
+	PressUser      bool
+	DisplayName    string
+	Username       string
+	AllowTelemetry bool
+}
+
+// Game is a minimal stand-in for a page on itch.io.
+type Game struct {
+	ID             int64
+	UserID         int64
+	Title          string
+	MinPrice       int64
+	Type           string
+	Classification string
+}
+
+// CanBeViewedBy reports whether user is allowed to see this game. Mitch
+// doesn't model real permissions, so every game is publicly viewable.
+func (g *Game) CanBeViewedBy(user *User) bool {
+	return true
+}
+
+// Upload is a single distributable file attached to a game, either
+// hosted directly on the CDN or backed by a wharf build.
+type Upload struct {
+	ID      int64
+	GameID  int64
+	Type    string
+	Storage string
+	Size    int64
+
+	Filename string
+	URL      string
+
+	PlatformLinux   bool
+	PlatformWindows bool
+	PlatformMac     bool
+
+	// Head is the ID of the current Build, for storage == "build" uploads.
+	Head int64
+}
+
+// CanBeDownloadedBy reports whether user is allowed to download this
+// upload. Mitch doesn't model real permissions, so every upload is
+// publicly downloadable.
+func (u *Upload) CanBeDownloadedBy(user *User) bool {
+	return true
+}
+
+// Build is a single wharf build for an upload, holding one or more
+// build files (archive, patch, signature, unpack, ...). Files can be
+// read and appended to from concurrent requests (a download racing an
+// upgrade-path computation, say), so all access goes through mu.
+type Build struct {
+	ID       int64
+	UploadID int64
+
+	mu    sync.Mutex
+	Files []*BuildFile
+}
+
+// BuildFile associates a (type, subtype) pair, e.g. ("archive", "default")
+// or ("patch", "default"), with the CDN file that serves it.
+type BuildFile struct {
+	Type    string
+	Subtype string
+	File    *CDNFile
+}
+
+// GetFile returns the CDN file for the given (type, subtype) pair, or nil
+// if the build doesn't have one.
+func (b *Build) GetFile(typ string, subtype string) *CDNFile {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, bf := range b.Files {
+		if bf.Type == typ && bf.Subtype == subtype {
+			return bf.File
+		}
+	}
+	return nil
+}
+
+// addFileIfAbsent registers f as build's (typ, subtype) file unless one
+// was already registered (by a concurrent caller) while f was being
+// prepared, in which case that existing file is returned instead.
+func (b *Build) addFileIfAbsent(typ string, subtype string, f *CDNFile) *CDNFile {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, bf := range b.Files {
+		if bf.Type == typ && bf.Subtype == subtype {
+			return bf.File
+		}
+	}
+	b.Files = append(b.Files, &BuildFile{Type: typ, Subtype: subtype, File: f})
+	return f
+}
+
+// CDNFile is a blob of bytes served from the `/@cdn` namespace.
+type CDNFile struct {
+	Path     string
+	Filename string
+	Size     int64
+	Contents []byte
+
+	// Digest is the file's "sha256:<hex>" content digest, set once it's
+	// known (e.g. after a resumable or multipart upload completes).
+	Digest string
+}
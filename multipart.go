@@ -0,0 +1,123 @@
+package mitch
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// MultipartUpload tracks an in-progress S3/B2-style multipart upload:
+// parts are stored independently as they arrive and only concatenated
+// once Complete is called with the client's manifest. Real multipart
+// clients upload parts in parallel, so parts has its own mutex rather
+// than reusing Store.mu.
+type MultipartUpload struct {
+	UploadID string
+	Upload   *Upload
+
+	mu    sync.Mutex
+	parts map[int64][]byte
+}
+
+// MultipartPart is a single {PartNumber, ETag} pair, as sent by the
+// client to the complete endpoint to specify order and let mitch verify
+// it received exactly the bytes the client thinks it did.
+type MultipartPart struct {
+	PartNumber int64  `json:"PartNumber"`
+	ETag       string `json:"ETag"`
+}
+
+// StartMultipartUpload opens a new multipart session for upload.
+func (s *Store) StartMultipartUpload(upload *Upload) *MultipartUpload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mu := &MultipartUpload{
+		UploadID: uuid.New().String(),
+		Upload:   upload,
+		parts:    make(map[int64][]byte),
+	}
+	s.MultipartUploads[mu.UploadID] = mu
+	return mu
+}
+
+// FindMultipartUpload looks up a session by upload ID and multipart
+// upload ID, returning nil if it doesn't exist or belongs to another
+// upload.
+func (s *Store) FindMultipartUpload(uploadID int64, multipartUploadID string) *MultipartUpload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mu := s.MultipartUploads[multipartUploadID]
+	if mu == nil || mu.Upload.ID != uploadID {
+		return nil
+	}
+	return mu
+}
+
+// PutPart stores a single part's bytes and returns its ETag (the hex md5
+// of its contents, as object stores do).
+func (mu *MultipartUpload) PutPart(partNumber int64, data []byte) string {
+	sum := md5.Sum(data)
+
+	mu.mu.Lock()
+	mu.parts[partNumber] = data
+	mu.mu.Unlock()
+
+	return hex.EncodeToString(sum[:])
+}
+
+// CompleteMultipartUpload concatenates the parts named in manifest, in order, verifying
+// each part's ETag matches what PutPart returned for it, then registers
+// the result as the upload's CDN file.
+func (s *Store) CompleteMultipartUpload(mu *MultipartUpload, manifest []MultipartPart) (*CDNFile, error) {
+	mu.mu.Lock()
+	parts := make(map[int64][]byte, len(mu.parts))
+	for k, v := range mu.parts {
+		parts[k] = v
+	}
+	mu.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, want := range manifest {
+		data, ok := parts[want.PartNumber]
+		if !ok {
+			return nil, fmt.Errorf("unknown part number %d", want.PartNumber)
+		}
+		sum := md5.Sum(data)
+		etag := hex.EncodeToString(sum[:])
+		if etag != want.ETag {
+			return nil, fmt.Errorf("part %d: etag mismatch: expected %s, got %s", want.PartNumber, want.ETag, etag)
+		}
+		buf.Write(data)
+	}
+
+	contents := buf.Bytes()
+	sum := sha256.Sum256(contents)
+	f := s.PutCDNFile(mu.Upload.URL, &CDNFile{
+		Filename: mu.Upload.Filename,
+		Size:     int64(len(contents)),
+		Contents: contents,
+		Digest:   "sha256:" + hex.EncodeToString(sum[:]),
+	})
+	mu.Upload.Size = f.Size
+
+	s.mu.Lock()
+	delete(s.MultipartUploads, mu.UploadID)
+	s.mu.Unlock()
+
+	return f, nil
+}
+
+// AbortMultipartUpload frees a session's buffered parts without writing
+// anything to the CDN.
+func (s *Store) AbortMultipartUpload(mu *MultipartUpload) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.MultipartUploads, mu.UploadID)
+}
@@ -0,0 +1,168 @@
+package mitch
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// TestMultipartUploadRoundTrip drives a full initiate -> parts -> complete
+// flow, uploading parts concurrently the way real S3/B2 clients do, then
+// verifies the completed CDN file matches the concatenated part bytes.
+func TestMultipartUploadRoundTrip(t *testing.T) {
+	base, store := newTestServer(t)
+
+	store.Games[1] = &Game{ID: 1}
+	store.Uploads[1] = &Upload{
+		ID:       1,
+		GameID:   1,
+		Storage:  "hosted",
+		URL:      "/uploads/1/archive",
+		Filename: "game.zip",
+	}
+
+	client := &http.Client{}
+
+	startResp, err := client.Do(newAuthedRequest(t, "POST", base+"/uploads/1/multipart", nil))
+	if err != nil {
+		t.Fatalf("initiate: %v", err)
+	}
+	defer startResp.Body.Close()
+	if startResp.StatusCode != 200 {
+		t.Fatalf("initiate: expected 200, got %d", startResp.StatusCode)
+	}
+	var started struct {
+		UploadID string `json:"uploadId"`
+	}
+	if err := json.NewDecoder(startResp.Body).Decode(&started); err != nil {
+		t.Fatalf("decoding initiate response: %v", err)
+	}
+	if started.UploadID == "" {
+		t.Fatalf("initiate: missing uploadId")
+	}
+
+	parts := map[int64][]byte{
+		1: bytes.Repeat([]byte("a"), 5),
+		2: bytes.Repeat([]byte("b"), 5),
+		3: bytes.Repeat([]byte("c"), 5),
+	}
+
+	etags := make(map[int64]string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for partNumber, data := range parts {
+		partNumber, data := partNumber, data
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			url := fmt.Sprintf("%s/uploads/1/multipart/%s/parts/%d", base, started.UploadID, partNumber)
+			req := newAuthedRequest(t, "PUT", url, data)
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Errorf("part %d: %v", partNumber, err)
+				return
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != 200 {
+				t.Errorf("part %d: expected 200, got %d", partNumber, resp.StatusCode)
+				return
+			}
+			mu.Lock()
+			etags[partNumber] = resp.Header.Get("ETag")
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	for partNumber, data := range parts {
+		sum := md5.Sum(data)
+		want := hex.EncodeToString(sum[:])
+		if etags[partNumber] != want {
+			t.Fatalf("part %d: ETag = %q, want %q", partNumber, etags[partNumber], want)
+		}
+	}
+
+	manifest := []MultipartPart{
+		{PartNumber: 1, ETag: etags[1]},
+		{PartNumber: 2, ETag: etags[2]},
+		{PartNumber: 3, ETag: etags[3]},
+	}
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatalf("marshal manifest: %v", err)
+	}
+
+	completeResp, err := client.Do(newAuthedRequest(t, "POST", base+"/uploads/1/multipart/"+started.UploadID+"/complete", body))
+	if err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	defer completeResp.Body.Close()
+	if completeResp.StatusCode != 200 {
+		respBody, _ := ioutil.ReadAll(completeResp.Body)
+		t.Fatalf("complete: expected 200, got %d: %s", completeResp.StatusCode, respBody)
+	}
+
+	downloadResp, err := client.Do(newAuthedRequest(t, "GET", base+"/uploads/1/download", nil))
+	if err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	defer downloadResp.Body.Close()
+	if downloadResp.StatusCode != 200 {
+		t.Fatalf("download: expected 200, got %d", downloadResp.StatusCode)
+	}
+	got, err := ioutil.ReadAll(downloadResp.Body)
+	if err != nil {
+		t.Fatalf("reading download: %v", err)
+	}
+	want := append(append(append([]byte{}, parts[1]...), parts[2]...), parts[3]...)
+	if string(got) != string(want) {
+		t.Fatalf("downloaded content = %q, want %q", got, want)
+	}
+}
+
+// TestMultipartUploadAbort verifies an aborted session can't be completed
+// or reused afterwards.
+func TestMultipartUploadAbort(t *testing.T) {
+	base, store := newTestServer(t)
+
+	store.Games[1] = &Game{ID: 1}
+	store.Uploads[1] = &Upload{ID: 1, GameID: 1, Storage: "hosted", URL: "/uploads/1/archive", Filename: "game.zip"}
+
+	client := &http.Client{}
+
+	startResp, err := client.Do(newAuthedRequest(t, "POST", base+"/uploads/1/multipart", nil))
+	if err != nil {
+		t.Fatalf("initiate: %v", err)
+	}
+	defer startResp.Body.Close()
+	var started struct {
+		UploadID string `json:"uploadId"`
+	}
+	if err := json.NewDecoder(startResp.Body).Decode(&started); err != nil {
+		t.Fatalf("decoding initiate response: %v", err)
+	}
+
+	abortResp, err := client.Do(newAuthedRequest(t, "DELETE", base+"/uploads/1/multipart/"+started.UploadID, nil))
+	if err != nil {
+		t.Fatalf("abort: %v", err)
+	}
+	abortResp.Body.Close()
+	if abortResp.StatusCode != 204 {
+		t.Fatalf("abort: expected 204, got %d", abortResp.StatusCode)
+	}
+
+	completeResp, err := client.Do(newAuthedRequest(t, "POST", base+"/uploads/1/multipart/"+started.UploadID+"/complete", []byte("[]")))
+	if err != nil {
+		t.Fatalf("complete after abort: %v", err)
+	}
+	defer completeResp.Body.Close()
+	if completeResp.StatusCode != 404 {
+		t.Fatalf("complete after abort: expected 404, got %d", completeResp.StatusCode)
+	}
+}
@@ -0,0 +1,131 @@
+package mitch
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// RespondToMap maps an HTTP method to the handler that should serve it.
+// Methods not present in the map are rejected with 405.
+type RespondToMap map[string]func()
+
+// response wraps a single in-flight request: the raw http.ResponseWriter
+// and Request, plus the Store and the resolved current user.
+type response struct {
+	s     *server
+	w     http.ResponseWriter
+	req   *http.Request
+	store *Store
+
+	status  int
+	headers http.Header
+
+	currentUser *User
+
+	// fault, when set by the dispatcher, is the FaultRule this request
+	// matched; the CDN byte-serving code in cdn.go consults it to
+	// truncate or corrupt the response.
+	fault *FaultRule
+}
+
+func (r *response) Header() http.Header {
+	if r.headers == nil {
+		r.headers = make(http.Header)
+	}
+	return r.headers
+}
+
+// WriteHeader flushes the headers accumulated via Header() and the
+// status code onto the underlying http.ResponseWriter.
+func (r *response) WriteHeader() {
+	h := r.w.Header()
+	for k, vs := range r.headers {
+		for _, v := range vs {
+			h.Add(k, v)
+		}
+	}
+	if r.status == 0 {
+		r.status = 200
+	}
+	r.w.WriteHeader(r.status)
+}
+
+func (r *response) RespondTo(m RespondToMap) {
+	fn, ok := m[r.req.Method]
+	if !ok {
+		Throw(405, fmt.Sprintf("method %s not allowed here", r.req.Method))
+	}
+	fn()
+}
+
+func (r *response) Var(name string) string {
+	return mux.Vars(r.req)[name]
+}
+
+func (r *response) Int64Var(name string) int64 {
+	val, err := strconv.ParseInt(r.Var(name), 10, 64)
+	if err != nil {
+		Throw(400, fmt.Sprintf("invalid %s", name))
+	}
+	return val
+}
+
+// CheckAPIKey resolves the current user from the Authorization header.
+// Mitch doesn't model real API keys, so any non-empty key authenticates
+// as the store's single fixture user.
+func (r *response) CheckAPIKey() {
+	if r.req.Header.Get("Authorization") == "" {
+		Throw(401, "missing api key")
+	}
+	r.currentUser = r.store.CurrentUser()
+}
+
+func (r *response) AssertAuthorization(allowed bool) {
+	if !allowed {
+		Throw(403, "not authorized")
+	}
+}
+
+func (r *response) WriteJSON(v Any) {
+	if r.status == 0 {
+		r.status = 200
+	}
+	r.Header().Set("content-type", "application/json")
+	r.WriteHeader()
+	json.NewEncoder(r.w).Encode(v)
+}
+
+func (r *response) WriteError(status int, messages ...string) {
+	r.status = status
+	r.Header().Set("content-type", "application/json")
+	r.WriteHeader()
+	json.NewEncoder(r.w).Encode(Any{"errors": messages})
+}
+
+func (r *response) FindGame(id int64) *Game {
+	game := r.store.FindGame(id)
+	if game == nil {
+		Throw(404, "game not found")
+	}
+	return game
+}
+
+func (r *response) FindUpload(id int64) *Upload {
+	upload := r.store.FindUpload(id)
+	if upload == nil {
+		Throw(404, "upload not found")
+	}
+	return upload
+}
+
+func (r *response) FindBuild(id int64) *Build {
+	build := r.store.FindBuild(id)
+	if build == nil {
+		Throw(404, "build not found")
+	}
+	return build
+}
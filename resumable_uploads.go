@@ -0,0 +1,112 @@
+package mitch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// ResumableUpload tracks an in-progress Docker-Registry-style chunked
+// upload for a build's archive file: a client opens a session, PATCHes
+// one or more byte ranges to it (resuming after a dropped connection by
+// re-opening at the last acknowledged offset), then PUTs to finalize.
+// Overlapping PATCHes on the same session are a realistic retry/resume
+// scenario, so buf has its own mutex rather than reusing Store.mu.
+type ResumableUpload struct {
+	UUID    string
+	BuildID int64
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// Offset is the number of bytes received so far, i.e. the end of the
+// `Range: 0-N` header mitch reports back to the client.
+func (ru *ResumableUpload) Offset() int64 {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+	return int64(ru.buf.Len())
+}
+
+// StartResumableUpload opens a new chunked upload session for buildID.
+func (s *Store) StartResumableUpload(buildID int64) *ResumableUpload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ru := &ResumableUpload{
+		UUID:    uuid.New().String(),
+		BuildID: buildID,
+	}
+	s.ResumableUploads[ru.UUID] = ru
+	return ru
+}
+
+// FindResumableUpload looks up a chunked upload session by build ID and
+// UUID, returning nil if it doesn't exist or belongs to another build.
+func (s *Store) FindResumableUpload(buildID int64, uuid string) *ResumableUpload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ru := s.ResumableUploads[uuid]
+	if ru == nil || ru.BuildID != buildID {
+		return nil
+	}
+	return ru
+}
+
+// AppendChunk writes data at the given start offset. Mitch only supports
+// clients that upload contiguous chunks in order (the common case for
+// both butler and docker-registry clients), so start must equal the
+// session's current offset.
+func (ru *ResumableUpload) AppendChunk(start int64, data []byte) error {
+	ru.mu.Lock()
+	defer ru.mu.Unlock()
+
+	if start != int64(ru.buf.Len()) {
+		return fmt.Errorf("out-of-order chunk: got offset %d, expected %d", start, ru.buf.Len())
+	}
+	ru.buf.Write(data)
+	return nil
+}
+
+// FinalizeResumableUpload verifies the accumulated bytes against the given digest
+// (e.g. "sha256:abcd...") and, if it matches, registers the archive as a
+// CDN file on the upload's build, returning that file.
+func (s *Store) FinalizeResumableUpload(ru *ResumableUpload, digest string) (*CDNFile, error) {
+	ru.mu.Lock()
+	contents := append([]byte{}, ru.buf.Bytes()...)
+	ru.mu.Unlock()
+
+	sum := sha256.Sum256(contents)
+	computed := "sha256:" + hex.EncodeToString(sum[:])
+	if digest != computed {
+		return nil, fmt.Errorf("digest mismatch: expected %s, computed %s", digest, computed)
+	}
+
+	s.mu.Lock()
+	build := s.Builds[ru.BuildID]
+	s.mu.Unlock()
+	if build == nil {
+		return nil, fmt.Errorf("build %d disappeared mid-upload", ru.BuildID)
+	}
+
+	path := fmt.Sprintf("/builds/%d/archive/default", build.ID)
+	f := s.PutCDNFile(path, &CDNFile{
+		Filename: fmt.Sprintf("build-%d.zip", build.ID),
+		Size:     int64(len(contents)),
+		Contents: contents,
+		Digest:   computed,
+	})
+
+	build.addFileIfAbsent("archive", "default", f)
+
+	s.mu.Lock()
+	delete(s.ResumableUploads, ru.UUID)
+	s.mu.Unlock()
+
+	return f, nil
+}
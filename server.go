@@ -1,16 +1,17 @@
 package mitch
 
 import (
-	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
-	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/handlers"
 
@@ -34,6 +35,9 @@ type server struct {
 
 type serverOpts struct {
 	port int
+
+	faultInjection bool
+	storage        Storage
 }
 
 type ServerOpt func(opts *serverOpts)
@@ -44,6 +48,24 @@ func WithPort(port int) ServerOpt {
 	}
 }
 
+// WithFaultInjection turns on the Store.FaultRules registry: once set,
+// requests matching a registered rule can be delayed, truncated,
+// corrupted, or made to fail with a chosen status, to exercise client
+// resume/retry logic deterministically.
+func WithFaultInjection() ServerOpt {
+	return func(opts *serverOpts) {
+		opts.faultInjection = true
+	}
+}
+
+// WithStorage picks the Storage backend CDN files are written to and
+// served from. Defaults to an in-process MemoryStorage.
+func WithStorage(storage Storage) ServerOpt {
+	return func(opts *serverOpts) {
+		opts.storage = storage
+	}
+}
+
 func NewServer(ctx context.Context, options ...ServerOpt) (Server, error) {
 	var opts serverOpts
 	for _, o := range options {
@@ -53,7 +75,7 @@ func NewServer(ctx context.Context, options ...ServerOpt) (Server, error) {
 	s := &server{
 		ctx:   ctx,
 		opts:  opts,
-		store: newStore(),
+		store: newStore(opts.storage),
 	}
 
 	err := s.start()
@@ -102,6 +124,24 @@ func (s *server) serve() {
 				req:   req,
 				store: s.store,
 			}
+
+			if s.opts.faultInjection {
+				if rule := s.store.FaultRules.Match(req.Method, req.URL.Path); rule != nil {
+					if rule.Latency > 0 {
+						time.Sleep(rule.Latency)
+					}
+					if rule.RejectRange && req.Header.Get("Range") != "" {
+						res.WriteError(416, "range requests are disabled for this path")
+						return
+					}
+					if rule.StatusOnHit != 0 {
+						res.WriteError(rule.StatusOnHit, "fault injected")
+						return
+					}
+					res.fault = rule
+				}
+			}
+
 			err := func() (retErr error) {
 				defer func() {
 					if r := recover(); r != nil {
@@ -186,6 +226,54 @@ func (s *server) serve() {
 		})
 	})
 
+	route("/builds/{id}/download-sessions", func(r *response) {
+		r.RespondTo(RespondToMap{
+			"POST": func() {
+				r.CheckAPIKey()
+				buildID := r.Int64Var("id")
+				build := r.FindBuild(buildID)
+				upload := r.FindUpload(build.UploadID)
+				r.AssertAuthorization(upload.CanBeDownloadedBy(r.currentUser))
+
+				session := Any{
+					"uuid": uuid.New().String(),
+				}
+
+				upgradePath := r.req.URL.Query().Get("upgrade_path")
+				if upgradePath != "" {
+					tokens := strings.SplitN(upgradePath, ",", 2)
+					if len(tokens) != 2 {
+						Throw(400, "upgrade_path must be fromBuild,toBuild")
+					}
+					fromBuildID, err1 := strconv.ParseInt(tokens[0], 10, 64)
+					toBuildID, err2 := strconv.ParseInt(tokens[1], 10, 64)
+					if err1 != nil || err2 != nil {
+						Throw(400, "upgrade_path must be fromBuild,toBuild")
+					}
+
+					chain, err := r.store.BuildUpgradePath(fromBuildID, toBuildID)
+					if err != nil {
+						Throw(404, err.Error())
+					}
+
+					var entries []Any
+					for _, b := range chain {
+						r.store.EnsureWharfPatch(b)
+						r.store.EnsureWharfSignature(b)
+						entries = append(entries, Any{
+							"build":        b.ID,
+							"patchUrl":     fmt.Sprintf("/builds/%d/download/patch/default", b.ID),
+							"signatureUrl": fmt.Sprintf("/builds/%d/download/signature/default", b.ID),
+						})
+					}
+					session["upgradePath"] = entries
+				}
+
+				r.WriteJSON(session)
+			},
+		})
+	})
+
 	route("/uploads/{id}/download", func(r *response) {
 		r.RespondTo(RespondToMap{
 			"GET": func() {
@@ -194,7 +282,7 @@ func (s *server) serve() {
 				upload := r.FindUpload(uploadID)
 				r.AssertAuthorization(upload.CanBeDownloadedBy(r.currentUser))
 				switch upload.Storage {
-				case "hosted":
+				case "hosted", "gcs", "s3":
 					r.ServeCDNAsset(upload)
 				case "build":
 					build := r.FindBuild(upload.Head)
@@ -210,6 +298,172 @@ func (s *server) serve() {
 		})
 	})
 
+	route("/uploads/{id}/multipart", func(r *response) {
+		r.RespondTo(RespondToMap{
+			"POST": func() {
+				r.CheckAPIKey()
+				upload := r.FindUpload(r.Int64Var("id"))
+				r.AssertAuthorization(upload.CanBeDownloadedBy(r.currentUser))
+				mu := r.store.StartMultipartUpload(upload)
+				r.WriteJSON(Any{
+					"uploadId": mu.UploadID,
+				})
+			},
+		})
+	})
+
+	route("/uploads/{id}/multipart/{uploadId}/parts/{partNumber}", func(r *response) {
+		r.RespondTo(RespondToMap{
+			"PUT": func() {
+				r.CheckAPIKey()
+				uploadID := r.Int64Var("id")
+				mu := r.store.FindMultipartUpload(uploadID, r.Var("uploadId"))
+				if mu == nil {
+					Throw(404, "no such multipart upload")
+				}
+				partNumber := r.Int64Var("partNumber")
+
+				data, err := ioutil.ReadAll(r.req.Body)
+				if err != nil {
+					Throw(400, "could not read part body")
+				}
+
+				etag := mu.PutPart(partNumber, data)
+				r.Header().Set("ETag", etag)
+				r.status = 200
+				r.WriteHeader()
+			},
+		})
+	})
+
+	route("/uploads/{id}/multipart/{uploadId}/complete", func(r *response) {
+		r.RespondTo(RespondToMap{
+			"POST": func() {
+				r.CheckAPIKey()
+				uploadID := r.Int64Var("id")
+				mu := r.store.FindMultipartUpload(uploadID, r.Var("uploadId"))
+				if mu == nil {
+					Throw(404, "no such multipart upload")
+				}
+
+				var manifest []MultipartPart
+				if err := json.NewDecoder(r.req.Body).Decode(&manifest); err != nil {
+					Throw(400, "invalid complete manifest")
+				}
+
+				f, err := r.store.CompleteMultipartUpload(mu, manifest)
+				if err != nil {
+					Throw(400, err.Error())
+				}
+
+				r.WriteJSON(Any{
+					"ok":     true,
+					"size":   f.Size,
+					"digest": f.Digest,
+				})
+			},
+		})
+	})
+
+	route("/uploads/{id}/multipart/{uploadId}", func(r *response) {
+		r.RespondTo(RespondToMap{
+			"DELETE": func() {
+				r.CheckAPIKey()
+				uploadID := r.Int64Var("id")
+				mu := r.store.FindMultipartUpload(uploadID, r.Var("uploadId"))
+				if mu == nil {
+					Throw(404, "no such multipart upload")
+				}
+				r.store.AbortMultipartUpload(mu)
+				r.status = 204
+				r.WriteHeader()
+			},
+		})
+	})
+
+	route("/builds/{id}/uploads", func(r *response) {
+		r.RespondTo(RespondToMap{
+			"POST": func() {
+				r.CheckAPIKey()
+				build := r.FindBuild(r.Int64Var("id"))
+				ru := r.store.StartResumableUpload(build.ID)
+
+				r.Header().Set("Location", fmt.Sprintf("/builds/%d/uploads/%s", build.ID, ru.UUID))
+				r.Header().Set("Docker-Upload-UUID", ru.UUID)
+				r.Header().Set("Range", "0-0")
+				r.status = 202
+				r.WriteHeader()
+			},
+		})
+	})
+
+	route("/builds/{id}/uploads/{uuid}", func(r *response) {
+		r.RespondTo(RespondToMap{
+			"PATCH": func() {
+				r.CheckAPIKey()
+				buildID := r.Int64Var("id")
+				ru := r.store.FindResumableUpload(buildID, r.Var("uuid"))
+				if ru == nil {
+					Throw(404, "no such upload session")
+				}
+
+				contentRange := r.req.Header.Get("Content-Range")
+				start := ru.Offset()
+				if contentRange != "" {
+					rangeTokens := strings.SplitN(contentRange, "-", 2)
+					if parsed, err := strconv.ParseInt(rangeTokens[0], 10, 64); err == nil {
+						start = parsed
+					}
+				}
+
+				chunk, err := ioutil.ReadAll(r.req.Body)
+				if err != nil {
+					Throw(400, "could not read chunk body")
+				}
+				if err := ru.AppendChunk(start, chunk); err != nil {
+					Throw(416, err.Error())
+				}
+
+				r.Header().Set("Location", fmt.Sprintf("/builds/%d/uploads/%s", buildID, ru.UUID))
+				r.Header().Set("Docker-Upload-UUID", ru.UUID)
+				r.Header().Set("Range", fmt.Sprintf("0-%d", ru.Offset()))
+				r.status = 202
+				r.WriteHeader()
+			},
+			"PUT": func() {
+				r.CheckAPIKey()
+				buildID := r.Int64Var("id")
+				ru := r.store.FindResumableUpload(buildID, r.Var("uuid"))
+				if ru == nil {
+					Throw(404, "no such upload session")
+				}
+
+				if chunk, err := ioutil.ReadAll(r.req.Body); err == nil && len(chunk) > 0 {
+					if err := ru.AppendChunk(ru.Offset(), chunk); err != nil {
+						Throw(416, err.Error())
+					}
+				}
+
+				digest := r.req.URL.Query().Get("digest")
+				if digest == "" {
+					Throw(400, "missing digest")
+				}
+
+				f, err := r.store.FinalizeResumableUpload(ru, digest)
+				if err != nil {
+					Throw(400, err.Error())
+				}
+
+				r.Header().Set("Docker-Upload-UUID", ru.UUID)
+				r.WriteJSON(Any{
+					"ok":     true,
+					"digest": digest,
+					"size":   f.Size,
+				})
+			},
+		})
+	})
+
 	route("/builds/{id}/download/{type}/{subtype}", func(r *response) {
 		r.RespondTo(RespondToMap{
 			"GET": func() {
@@ -237,47 +491,11 @@ func (s *server) serve() {
 			"GET": func() {
 				path := r.req.URL.Path
 				path = strings.TrimPrefix(path, "/@cdn")
-				f := r.store.CDNFiles[path]
+				f := r.store.FindCDNFile(path)
 				if f == nil {
 					Throw(404, "not found")
 				}
-
-				contentLength := f.Size
-				rangeHeader := r.req.Header.Get("Range")
-				data := f.Contents
-				if rangeHeader == "" {
-					r.status = 200
-				} else {
-					rangeTokens := strings.Split(rangeHeader, "=")
-					byteTokens := strings.Split(rangeTokens[1], "-")
-
-					start := int64(0)
-					if startVal, err := strconv.ParseInt(byteTokens[0], 10, 64); err == nil {
-						start = startVal
-					}
-					end := f.Size - 1
-					if endVal, err := strconv.ParseInt(byteTokens[1], 10, 64); err == nil {
-						end = endVal
-					}
-
-					// note that the server will return internal error if the range is invalid
-					data = data[start : end+1]
-					contentLength = end + 1 - start
-					r.status = 206
-					r.Header().Set("content-range", fmt.Sprintf("bytes %d-%d/%d", start, end, f.Size))
-				}
-
-				r.Header().Set("content-length", fmt.Sprintf("%d", contentLength))
-				r.Header().Set("accept-range", "bytes")
-				r.Header().Set("content-type", "application/octet-stream")
-				r.Header().Set("content-disposition", fmt.Sprintf("attachment; filename=%q", f.Filename))
-				r.Header().Set("connection", "close")
-				r.WriteHeader()
-
-				src := bytes.NewReader(data)
-				log.Printf("Serving %s", f.Filename)
-				io.Copy(r.w, src)
-				log.Printf("Serving %s (done)", f.Filename)
+				serveCDNFile(r, f)
 			},
 		})
 	})
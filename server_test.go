@@ -0,0 +1,66 @@
+package mitch
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// newTestServer starts a mitch server on an ephemeral port for the
+// duration of the test and returns its base URL and Store, so tests can
+// seed fixtures directly and then drive the HTTP API end to end.
+func newTestServer(t *testing.T, opts ...ServerOpt) (string, *Store) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	srv, err := NewServer(ctx, append([]ServerOpt{WithPort(0)}, opts...)...)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	base := fmt.Sprintf("http://%s", srv.Address().String())
+	waitForServer(t, base)
+	return base, srv.Store()
+}
+
+// waitForServer blocks until base accepts connections, since serve()
+// starts in its own goroutine after NewServer returns.
+func waitForServer(t *testing.T, base string) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := http.Get(base + "/profile")
+		if err == nil {
+			resp.Body.Close()
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("server at %s never came up", base)
+}
+
+// newAuthedRequest builds a request carrying whatever Authorization
+// header CheckAPIKey requires (mitch doesn't model real API keys, so
+// any non-empty value authenticates).
+func newAuthedRequest(t *testing.T, method, url string, body []byte) *http.Request {
+	t.Helper()
+
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, url, r)
+	if err != nil {
+		t.Fatalf("NewRequest %s %s: %v", method, url, err)
+	}
+	req.Header.Set("Authorization", "Bearer test-api-key")
+	return req
+}
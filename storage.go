@@ -0,0 +1,187 @@
+package mitch
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage is the backend a Store uses to persist and serve CDN file
+// bytes. Swapping it out lets mitch simulate the different ways
+// itch.io actually stores uploads: in-process (the default, fastest
+// for unit tests), on disk, or proxied to an external object-store
+// fake such as fake-gcs-server or MinIO.
+type Storage interface {
+	// Put stores data under key and returns a URL it can be fetched back
+	// from: a mitch `/@cdn` URL for MemoryStorage and DirStorage, the
+	// backend's own object URL for ProxyStorage.
+	Put(key string, data []byte) (url string, err error)
+	// Get returns a reader over the bytes stored under key, along with
+	// their size. The caller must Close the reader.
+	Get(key string) (rc io.ReadCloser, size int64, err error)
+	// Delete removes whatever's stored under key, if anything.
+	Delete(key string) error
+	// SignedURL returns a URL clients can be redirected to for key, with
+	// ttl carried as a query parameter. ProxyStorage's URL points at the
+	// backend directly; MemoryStorage and DirStorage return a mitch
+	// `/@cdn` URL. No backend here enforces the ttl.
+	SignedURL(key string, ttl time.Duration) (string, error)
+}
+
+// MemoryStorage is the default Storage: an in-process map, equivalent
+// to the CDNFiles map Store used to hold directly.
+type MemoryStorage struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{files: make(map[string][]byte)}
+}
+
+func (ms *MemoryStorage) Put(key string, data []byte) (string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.files[key] = data
+	return "/@cdn" + key, nil
+}
+
+func (ms *MemoryStorage) Get(key string) (io.ReadCloser, int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	data, ok := ms.files[key]
+	if !ok {
+		return nil, 0, os.ErrNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+}
+
+func (ms *MemoryStorage) Delete(key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.files, key)
+	return nil
+}
+
+func (ms *MemoryStorage) SignedURL(key string, ttl time.Duration) (string, error) {
+	return fmt.Sprintf("/@cdn%s?signed=1&ttl=%d", key, int64(ttl.Seconds())), nil
+}
+
+// DirStorage stores files under a directory on disk, keyed by path.
+type DirStorage struct {
+	root string
+}
+
+func NewDirStorage(root string) *DirStorage {
+	return &DirStorage{root: root}
+}
+
+func (ds *DirStorage) diskPath(key string) string {
+	return filepath.Join(ds.root, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+func (ds *DirStorage) Put(key string, data []byte) (string, error) {
+	p := ds.diskPath(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(p, data, 0644); err != nil {
+		return "", err
+	}
+	return "/@cdn" + key, nil
+}
+
+func (ds *DirStorage) Get(key string) (io.ReadCloser, int64, error) {
+	f, err := os.Open(ds.diskPath(key))
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+func (ds *DirStorage) Delete(key string) error {
+	err := os.Remove(ds.diskPath(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (ds *DirStorage) SignedURL(key string, ttl time.Duration) (string, error) {
+	return fmt.Sprintf("/@cdn%s?signed=1&ttl=%d", key, int64(ttl.Seconds())), nil
+}
+
+// ProxyStorage forwards Put/Get/Delete to an external object-store
+// fake, such as fake-gcs-server or a MinIO instance, reached at
+// BaseURL. This lets mitch be used in front of the same bucket API a
+// real GCS/S3 client would hit.
+type ProxyStorage struct {
+	BaseURL string
+	client  *http.Client
+}
+
+func NewProxyStorage(baseURL string) *ProxyStorage {
+	return &ProxyStorage{BaseURL: baseURL, client: http.DefaultClient}
+}
+
+func (ps *ProxyStorage) objectURL(key string) string {
+	return strings.TrimRight(ps.BaseURL, "/") + "/" + strings.TrimLeft(key, "/")
+}
+
+func (ps *ProxyStorage) Put(key string, data []byte) (string, error) {
+	req, err := http.NewRequest("PUT", ps.objectURL(key), bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	resp, err := ps.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("proxy storage: PUT %s: unexpected status %d", key, resp.StatusCode)
+	}
+	return ps.objectURL(key), nil
+}
+
+func (ps *ProxyStorage) Get(key string) (io.ReadCloser, int64, error) {
+	resp, err := ps.client.Get(ps.objectURL(key))
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("proxy storage: GET %s: unexpected status %d", key, resp.StatusCode)
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+func (ps *ProxyStorage) Delete(key string) error {
+	req, err := http.NewRequest("DELETE", ps.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := ps.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (ps *ProxyStorage) SignedURL(key string, ttl time.Duration) (string, error) {
+	return fmt.Sprintf("%s?X-Goog-Expires=%d", ps.objectURL(key), int64(ttl.Seconds())), nil
+}
@@ -0,0 +1,195 @@
+package mitch
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestMemoryStorageRoundTrip covers the default Storage backend's
+// Put/Get/Delete contract directly.
+func TestMemoryStorageRoundTrip(t *testing.T) {
+	ms := NewMemoryStorage()
+
+	url, err := ms.Put("/builds/1/archive/default", []byte("payload"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if url != "/@cdn/builds/1/archive/default" {
+		t.Fatalf("Put: url = %q, want %q", url, "/@cdn/builds/1/archive/default")
+	}
+
+	rc, size, err := ms.Get("/builds/1/archive/default")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer rc.Close()
+	if size != 7 {
+		t.Fatalf("Get: size = %d, want 7", size)
+	}
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("reading Get result: %v", err)
+	}
+	if string(data) != "payload" {
+		t.Fatalf("Get: data = %q, want %q", data, "payload")
+	}
+
+	if err := ms.Delete("/builds/1/archive/default"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, _, err := ms.Get("/builds/1/archive/default"); err == nil {
+		t.Fatalf("Get after Delete: expected an error, got none")
+	}
+}
+
+// TestDirStorageRoundTrip covers DirStorage against a scratch directory.
+func TestDirStorageRoundTrip(t *testing.T) {
+	ds := NewDirStorage(t.TempDir())
+
+	if _, err := ds.Put("/builds/1/archive/default", []byte("disk payload")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	rc, size, err := ds.Get("/builds/1/archive/default")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer rc.Close()
+	if size != int64(len("disk payload")) {
+		t.Fatalf("Get: size = %d, want %d", size, len("disk payload"))
+	}
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("reading Get result: %v", err)
+	}
+	if string(data) != "disk payload" {
+		t.Fatalf("Get: data = %q, want %q", data, "disk payload")
+	}
+
+	if err := ds.Delete("/builds/1/archive/default"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, _, err := ds.Get("/builds/1/archive/default"); err == nil {
+		t.Fatalf("Get after Delete: expected an error, got none")
+	}
+}
+
+// fakeObjectStore is a minimal stand-in for a fake-gcs-server or MinIO
+// endpoint: PUT stores the request body under its path, GET returns it
+// (404 if absent), DELETE removes it.
+type fakeObjectStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newFakeObjectStore() *fakeObjectStore {
+	return &fakeObjectStore{objects: make(map[string][]byte)}
+}
+
+func (fs *fakeObjectStore) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	switch req.Method {
+	case "PUT":
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+		fs.objects[req.URL.Path] = data
+	case "GET":
+		data, ok := fs.objects[req.URL.Path]
+		if !ok {
+			w.WriteHeader(404)
+			return
+		}
+		w.Write(data)
+	case "DELETE":
+		delete(fs.objects, req.URL.Path)
+	default:
+		w.WriteHeader(405)
+	}
+}
+
+// TestProxyStorageRoundTrip covers ProxyStorage against an httptest.Server
+// standing in for an external fake-gcs-server/MinIO endpoint.
+func TestProxyStorageRoundTrip(t *testing.T) {
+	fake := newFakeObjectStore()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	ps := NewProxyStorage(server.URL)
+
+	url, err := ps.Put("/builds/1/archive/default", []byte("proxied payload"))
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if !strings.HasPrefix(url, server.URL) {
+		t.Fatalf("Put: url = %q, want it to start with %q", url, server.URL)
+	}
+
+	rc, size, err := ps.Get("/builds/1/archive/default")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer rc.Close()
+	if size != int64(len("proxied payload")) {
+		t.Fatalf("Get: size = %d, want %d", size, len("proxied payload"))
+	}
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("reading Get result: %v", err)
+	}
+	if string(data) != "proxied payload" {
+		t.Fatalf("Get: data = %q, want %q", data, "proxied payload")
+	}
+
+	signed, err := ps.SignedURL("/builds/1/archive/default", 0)
+	if err != nil {
+		t.Fatalf("SignedURL: %v", err)
+	}
+	if !strings.Contains(signed, "X-Goog-Expires=") {
+		t.Fatalf("SignedURL: %q missing expected query param", signed)
+	}
+
+	if err := ps.Delete("/builds/1/archive/default"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, _, err := ps.Get("/builds/1/archive/default"); err == nil {
+		t.Fatalf("Get after Delete: expected an error, got none")
+	}
+}
+
+// TestGCSUploadRedirects verifies that an upload stored on an external
+// "gcs"/"s3" backend is served as a 302 redirect to a signed URL rather
+// than having mitch stream the bytes itself.
+func TestGCSUploadRedirects(t *testing.T) {
+	base, store := newTestServer(t, WithStorage(NewMemoryStorage()))
+
+	store.Games[1] = &Game{ID: 1}
+	store.Uploads[1] = &Upload{ID: 1, GameID: 1, Storage: "gcs", URL: "/uploads/1/archive"}
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Do(newAuthedRequest(t, "GET", base+"/uploads/1/download", nil))
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 302 {
+		t.Fatalf("status = %d, want 302", resp.StatusCode)
+	}
+	location := resp.Header.Get("Location")
+	if location == "" {
+		t.Fatalf("missing Location header")
+	}
+}
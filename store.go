@@ -0,0 +1,156 @@
+package mitch
+
+import (
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// Store holds every piece of state mitch serves: games, uploads, builds
+// and the CDN files backing them. Tests reach into it directly (via
+// Server.Store()) to seed fixtures before hitting the HTTP API.
+type Store struct {
+	mu sync.Mutex
+
+	nextID int64
+
+	Games   map[int64]*Game
+	Uploads map[int64]*Upload
+	Builds  map[int64]*Build
+
+	storage Storage
+	cdnMeta map[string]*CDNFile
+
+	ResumableUploads map[string]*ResumableUpload
+	MultipartUploads map[string]*MultipartUpload
+
+	FaultRules *FaultRules
+
+	currentUser *User
+}
+
+// newStore creates a Store backed by storage. A nil storage defaults to
+// an in-process MemoryStorage, which is what you want unless a test is
+// specifically exercising an on-disk or cloud-storage-proxy backend.
+func newStore(storage Storage) *Store {
+	if storage == nil {
+		storage = NewMemoryStorage()
+	}
+
+	return &Store{
+		Games:   make(map[int64]*Game),
+		Uploads: make(map[int64]*Upload),
+		Builds:  make(map[int64]*Build),
+
+		storage: storage,
+		cdnMeta: make(map[string]*CDNFile),
+
+		ResumableUploads: make(map[string]*ResumableUpload),
+		MultipartUploads: make(map[string]*MultipartUpload),
+
+		FaultRules: newFaultRules(),
+
+		currentUser: &User{ID: 1, Developer: true, Username: "mitch"},
+	}
+}
+
+func (s *Store) CurrentUser() *User {
+	return s.currentUser
+}
+
+func (s *Store) newID() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	return s.nextID
+}
+
+func (s *Store) FindGame(id int64) *Game {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Games[id]
+}
+
+func (s *Store) FindUpload(id int64) *Upload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Uploads[id]
+}
+
+func (s *Store) FindBuild(id int64) *Build {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Builds[id]
+}
+
+func (s *Store) ListUploadsByGame(gameID int64) []*Upload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var res []*Upload
+	for _, u := range s.Uploads {
+		if u.GameID == gameID {
+			res = append(res, u)
+		}
+	}
+	return res
+}
+
+// PutCDNFile writes f's contents to the Store's Storage backend under
+// path and records its metadata, overwriting any file already there.
+func (s *Store) PutCDNFile(path string, f *CDNFile) *CDNFile {
+	f.Path = path
+	if _, err := s.storage.Put(path, f.Contents); err != nil {
+		// mitch is a test double: losing a file silently would just
+		// produce a confusing 404 later, so fail loudly instead.
+		panic(err)
+	}
+
+	// Only metadata is cached here; the bytes themselves live in
+	// storage and are re-fetched by FindCDNFile, so DirStorage and
+	// ProxyStorage don't end up keeping every upload's contents
+	// resident in process memory too.
+	meta := *f
+	meta.Contents = nil
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cdnMeta[path] = &meta
+	return f
+}
+
+// FindCDNFile looks up the file registered under path, reading its
+// current bytes back from the Storage backend. Returns nil if no file
+// is registered there, or if the backend can no longer produce it.
+func (s *Store) FindCDNFile(path string) *CDNFile {
+	s.mu.Lock()
+	meta, ok := s.cdnMeta[path]
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	rc, size, err := s.storage.Get(path)
+	if err != nil {
+		return nil
+	}
+	defer rc.Close()
+
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil
+	}
+
+	clone := *meta
+	clone.Contents = data
+	clone.Size = size
+	return &clone
+}
+
+// SignedURL asks the Storage backend for a URL to path that clients can
+// be redirected to (see Storage.SignedURL for where each backend's URL
+// points). Used for uploads whose Storage is "gcs" or "s3", to exercise
+// client code that branches on cloud-storage redirects.
+func (s *Store) SignedURL(path string, ttl time.Duration) (string, error) {
+	return s.storage.SignedURL(path, ttl)
+}
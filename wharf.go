@@ -0,0 +1,121 @@
+package mitch
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// Wharf container magic numbers, as defined by the itch.io wharf
+// protocol (see itchio/wharf's pwr package).
+const (
+	wharfPatchMagic     int32 = 0xFEF5F0
+	wharfSignatureMagic int32 = 0xF00BA5
+)
+
+// BuildUpgradePath resolves the chain of builds between fromBuildID
+// (exclusive) and toBuildID (inclusive), assuming builds are numbered
+// sequentially for a given upload, the way wharf builds are.
+func (s *Store) BuildUpgradePath(fromBuildID, toBuildID int64) ([]*Build, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if toBuildID < fromBuildID {
+		return nil, fmt.Errorf("upgrade_path: toBuild %d precedes fromBuild %d", toBuildID, fromBuildID)
+	}
+
+	var chain []*Build
+	for id := fromBuildID + 1; id <= toBuildID; id++ {
+		b := s.Builds[id]
+		if b == nil {
+			return nil, fmt.Errorf("upgrade_path: missing build %d", id)
+		}
+		chain = append(chain, b)
+	}
+	return chain, nil
+}
+
+// EnsureWharfPatch returns build's "patch/default" file, synthesizing a
+// minimal valid wharf patch container for it (a single COPY op covering
+// the whole archive, with a trailing signature block) if none was
+// pre-seeded.
+func (s *Store) EnsureWharfPatch(build *Build) *CDNFile {
+	if f := build.GetFile("patch", "default"); f != nil {
+		return f
+	}
+
+	var size int64
+	if archive := build.GetFile("archive", "default"); archive != nil {
+		size = archive.Size
+	}
+	data := synthesizeWharfPatch(size)
+
+	path := fmt.Sprintf("/builds/%d/patch/default", build.ID)
+	f := s.PutCDNFile(path, &CDNFile{
+		Filename: fmt.Sprintf("build-%d.pwr", build.ID),
+		Size:     int64(len(data)),
+		Contents: data,
+	})
+
+	return build.addFileIfAbsent("patch", "default", f)
+}
+
+// EnsureWharfSignature returns build's "signature/default" file,
+// synthesizing a minimal valid wharf signature container (a single
+// whole-file hash block) if none was pre-seeded.
+func (s *Store) EnsureWharfSignature(build *Build) *CDNFile {
+	if f := build.GetFile("signature", "default"); f != nil {
+		return f
+	}
+
+	var contents []byte
+	if archive := build.GetFile("archive", "default"); archive != nil {
+		contents = archive.Contents
+	}
+	data := synthesizeWharfSignature(contents)
+
+	path := fmt.Sprintf("/builds/%d/signature/default", build.ID)
+	f := s.PutCDNFile(path, &CDNFile{
+		Filename: fmt.Sprintf("build-%d.pwr.sig", build.ID),
+		Size:     int64(len(data)),
+		Contents: data,
+	})
+
+	return build.addFileIfAbsent("signature", "default", f)
+}
+
+// synthesizeWharfPatch builds a minimal wharf patch container: a magic
+// number, a recipe made of a single COPY op spanning the whole target
+// size, and a trailing signature block: the signature magic followed by
+// the sha256 of the preceding bytes (patch magic and recipe).
+func synthesizeWharfPatch(targetSize int64) []byte {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, uint32(wharfPatchMagic))
+
+	// recipe: op count (1), op kind (0 == COPY), offset, length
+	recipe := make([]byte, 8+1+8+8)
+	binary.LittleEndian.PutUint64(recipe[0:8], 1)
+	recipe[8] = 0 // COPY
+	binary.LittleEndian.PutUint64(recipe[9:17], 0)
+	binary.LittleEndian.PutUint64(recipe[17:25], uint64(targetSize))
+	buf = append(buf, recipe...)
+
+	sigMagic := make([]byte, 4)
+	binary.LittleEndian.PutUint32(sigMagic, uint32(wharfSignatureMagic))
+	buf = append(buf, sigMagic...)
+
+	sum := sha256.Sum256(buf)
+	buf = append(buf, sum[:]...)
+	return buf
+}
+
+// synthesizeWharfSignature builds a minimal wharf signature container: a
+// magic number followed by a single whole-file hash block.
+func synthesizeWharfSignature(contents []byte) []byte {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, uint32(wharfSignatureMagic))
+
+	sum := sha256.Sum256(contents)
+	buf = append(buf, sum[:]...)
+	return buf
+}